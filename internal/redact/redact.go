@@ -0,0 +1,34 @@
+// Copyright 2020 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redact provides utilities for redacting sensitive information
+// from logs and error messages.
+package redact
+
+import "context"
+
+type redactKey struct{}
+
+// NewContext returns a context that can be checked with FromContext to
+// see if content should be redacted, and why.
+func NewContext(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, redactKey{}, reason)
+}
+
+// FromContext returns whether the provided context's request/response
+// bodies should be redacted, and the reason if so.
+func FromContext(ctx context.Context) (bool, string) {
+	reason, ok := ctx.Value(redactKey{}).(string)
+	return ok, reason
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redact
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestURL(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{{
+		name: "s3 presigned params stripped",
+		in:   "https://example-bucket.s3.amazonaws.com/blob?X-Amz-Signature=secret&X-Amz-Credential=akid&X-Amz-Algorithm=AWS4&other=keep",
+		want: "https://example-bucket.s3.amazonaws.com/blob?other=keep",
+	}, {
+		name: "gcs v4 signed params stripped",
+		in:   "https://storage.googleapis.com/blob?X-Goog-Signature=secret&X-Goog-Credential=cred&other=keep",
+		want: "https://storage.googleapis.com/blob?other=keep",
+	}, {
+		name: "azure sas params stripped",
+		in:   "https://acct.blob.core.windows.net/blob?sig=secret&se=2024&sv=2020-01-01&other=keep",
+		want: "https://acct.blob.core.windows.net/blob?other=keep",
+	}, {
+		name: "generic signature params stripped",
+		in:   "https://example.com/blob?Signature=secret&AccessKeyId=akid&other=keep",
+		want: "https://example.com/blob?other=keep",
+	}, {
+		name: "no query string",
+		in:   "https://example.com/blob",
+		want: "https://example.com/blob",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := url.Parse(tt.in)
+			if err != nil {
+				t.Fatalf("url.Parse(%q): %v", tt.in, err)
+			}
+			got := URL(in)
+			if got.String() != tt.want {
+				t.Errorf("URL(%q) = %q, want %q", tt.in, got.String(), tt.want)
+			}
+			// URL must not mutate its argument.
+			if in.String() != tt.in {
+				t.Errorf("URL mutated its argument: got %q, want %q", in.String(), tt.in)
+			}
+		})
+	}
+}
+
+func TestURLNil(t *testing.T) {
+	if got := URL(nil); got != nil {
+		t.Errorf("URL(nil) = %v, want nil", got)
+	}
+}
+
+func TestRegisterQueryParamDenylist(t *testing.T) {
+	RegisterQueryParamDenylist("acme", []string{"X-Acme-Token"})
+
+	in, err := url.Parse("https://example.com/blob?X-Acme-Token=secret&keep=1")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	got := URL(in).String()
+	want := "https://example.com/blob?keep=1"
+	if got != want {
+		t.Errorf("URL() after RegisterQueryParamDenylist = %q, want %q", got, want)
+	}
+}
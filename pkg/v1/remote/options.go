@@ -0,0 +1,82 @@
+// Copyright 2024 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// Transport is the default transport used by this package's Write, Pull,
+// and index-walking functions when an Option doesn't override it with a
+// more specific transport. Wrapping it (or overriding it process-wide)
+// is how callers get transport.NewLogger, transport.NewTracer, etc.
+// applied without touching every call site.
+var Transport http.RoundTripper = http.DefaultTransport
+
+// options collects the knobs set by With* Option funcs that affect the
+// transport chain built for a remote call.
+type options struct {
+	transport http.RoundTripper
+
+	tracerProvider    trace.TracerProvider
+	metricsRegisterer prometheus.Registerer
+}
+
+// Option is a functional option for remote operations.
+type Option func(*options)
+
+// WithTracerProvider instruments the transport chain with an
+// OpenTelemetry span per request, using tp to create the tracer. See
+// transport.NewTracer for the attributes and events recorded on each
+// span. This gives end-to-end traces for pushes and pulls without
+// wrapping the transport by hand.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) { o.tracerProvider = tp }
+}
+
+// WithMetricsRegisterer instruments the transport chain with Prometheus
+// counters and histograms for every registry HTTP call, registering its
+// collectors with reg. See transport.NewMetrics for what's exported.
+// This gives remote.Write, remote.Pull, and index walks per-layer
+// upload/download throughput without any extra instrumentation at the
+// call site.
+func WithMetricsRegisterer(reg prometheus.Registerer) Option {
+	return func(o *options) { o.metricsRegisterer = reg }
+}
+
+// makeOptions applies opts over a base transport of Transport, layering
+// any configured instrumentation on top, and returns the result.
+func makeOptions(opts ...Option) *options {
+	o := &options{transport: Transport}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	rt := o.transport
+	if o.tracerProvider != nil {
+		rt = transport.NewTracer(rt, o.tracerProvider)
+	}
+	if o.metricsRegisterer != nil {
+		rt = transport.NewMetrics(rt, o.metricsRegisterer)
+	}
+	o.transport = rt
+
+	return o
+}
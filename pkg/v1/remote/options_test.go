@@ -0,0 +1,54 @@
+// Copyright 2024 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestMakeOptionsDefaultsToPackageTransport(t *testing.T) {
+	o := makeOptions()
+	if o.transport != Transport {
+		t.Errorf("makeOptions().transport = %v, want the package-level Transport var unchanged when no options are set", o.transport)
+	}
+}
+
+func TestMakeOptionsWithTracerProviderWrapsTransport(t *testing.T) {
+	o := makeOptions(WithTracerProvider(noop.NewTracerProvider()))
+	if o.transport == Transport {
+		t.Error("makeOptions(WithTracerProvider(...)).transport was left unwrapped")
+	}
+}
+
+func TestMakeOptionsWithMetricsRegistererWrapsTransport(t *testing.T) {
+	o := makeOptions(WithMetricsRegisterer(prometheus.NewRegistry()))
+	if o.transport == Transport {
+		t.Error("makeOptions(WithMetricsRegisterer(...)).transport was left unwrapped")
+	}
+}
+
+func TestMakeOptionsComposesBothInstruments(t *testing.T) {
+	o1 := makeOptions(WithTracerProvider(noop.NewTracerProvider()))
+	o2 := makeOptions(
+		WithTracerProvider(noop.NewTracerProvider()),
+		WithMetricsRegisterer(prometheus.NewRegistry()),
+	)
+	if o1.transport == o2.transport {
+		t.Error("adding WithMetricsRegisterer on top of WithTracerProvider did not change the resulting transport chain")
+	}
+}
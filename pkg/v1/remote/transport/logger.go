@@ -34,6 +34,11 @@ type redirectLogTransport struct {
 	inner http.RoundTripper
 }
 
+// NewRedirectLogger returns a transport that writes the (query-param
+// redacted) Location header of 307 redirects to
+// github.com/google/go-containerregistry/pkg/logs.File. It no longer
+// rewrites the response status code or strips the Location header, so
+// callers other than logging see the real redirect.
 func NewRedirectLogger(inner http.RoundTripper) http.RoundTripper {
 	return &redirectLogTransport{inner}
 }
@@ -46,28 +51,13 @@ func NewLogger(inner http.RoundTripper) http.RoundTripper {
 
 func (t *redirectLogTransport) RoundTrip(in *http.Request) (out *http.Response, err error) {
 	out, err = t.inner.RoundTrip(in)
-	if out != nil {
-		if out.StatusCode == 307 {
-			urlStr := out.Header.Get("Location")
-			s3url, err := url.Parse(urlStr)
-			if err != nil {
-				log.Printf("Failed to parse Location header %s: %v", urlStr, err)
-			}
-
-			q := s3url.Query()
-			q.Del("X-Amz-Signature")
-			q.Del("X-Amz-Credential")
-			q.Del("X-Amz-Algorithm")
-			q.Del("X-Amz-Date")
-			q.Del("X-Amz-Expires")
-			q.Del("X-Amz-SignedHeaders")
-
-			s3url.RawQuery = q.Encode()
-
-			logs.File.WriteString(s3url.String())
-
-			out.StatusCode = 200
-			out.Header.Del("Location")
+	if out != nil && out.StatusCode == http.StatusTemporaryRedirect {
+		urlStr := out.Header.Get("Location")
+		redirectURL, perr := url.Parse(urlStr)
+		if perr != nil {
+			log.Printf("Failed to parse Location header %s: %v", urlStr, perr)
+		} else {
+			logs.File.WriteString(redact.URL(redirectURL).String())
 		}
 	}
 	return out, err
@@ -79,9 +69,9 @@ func (t *logTransport) RoundTrip(in *http.Request) (out *http.Response, err erro
 	// We redact token responses and binary blobs in response/request.
 	omitBody, reason := redact.FromContext(in.Context())
 	if omitBody {
-		logs.Debug.Printf("--> %s %s [body redacted: %s]", in.Method, in.URL, reason)
+		logs.Debug.Printf("--> %s %s [body redacted: %s]", in.Method, redact.URL(in.URL), reason)
 	} else {
-		logs.Debug.Printf("--> %s %s", in.Method, in.URL)
+		logs.Debug.Printf("--> %s %s", in.Method, redact.URL(in.URL))
 	}
 
 	// Save these headers so we can redact Authorization.
@@ -90,12 +80,22 @@ func (t *logTransport) RoundTrip(in *http.Request) (out *http.Response, err erro
 		in.Header.Set("authorization", "<redacted>")
 	}
 
-	b, err := httputil.DumpRequestOut(in, !omitBody)
+	// Dump a clone with its URL redacted, not in itself: DumpRequestOut's
+	// request line includes the raw RequestURI, which would otherwise
+	// leak presigned/SAS query params that the --> summary line above
+	// already redacts.
+	dumpReq := in.Clone(in.Context())
+	dumpReq.URL = redact.URL(in.URL)
+	b, err := httputil.DumpRequestOut(dumpReq, !omitBody)
 	if err == nil {
 		logs.Debug.Println(string(b))
 	} else {
-		logs.Debug.Printf("Failed to dump request %s %s: %v", in.Method, in.URL, err)
+		logs.Debug.Printf("Failed to dump request %s %s: %v", in.Method, redact.URL(in.URL), err)
 	}
+	// DumpRequestOut tees dumpReq.Body through a buffer and replaces it
+	// with a restored copy; propagate that back so the real request
+	// still has a readable body.
+	in.Body = dumpReq.Body
 
 	// Restore the non-redacted headers.
 	in.Header = savedHeaders
@@ -104,12 +104,12 @@ func (t *logTransport) RoundTrip(in *http.Request) (out *http.Response, err erro
 	out, err = t.inner.RoundTrip(in)
 	duration := time.Since(start)
 	if err != nil {
-		logs.Debug.Printf("<-- %v %s %s (%s)", err, in.Method, in.URL, duration)
+		logs.Debug.Printf("<-- %v %s %s (%s)", err, in.Method, redact.URL(in.URL), duration)
 	}
 	if out != nil {
 		msg := fmt.Sprintf("<-- %d", out.StatusCode)
 		if out.Request != nil {
-			msg = fmt.Sprintf("%s %s", msg, out.Request.URL)
+			msg = fmt.Sprintf("%s %s", msg, redact.URL(out.Request.URL))
 		}
 		msg = fmt.Sprintf("%s (%s)", msg, duration)
 
@@ -123,7 +123,7 @@ func (t *logTransport) RoundTrip(in *http.Request) (out *http.Response, err erro
 		if err == nil {
 			logs.Debug.Println(string(b))
 		} else {
-			logs.Debug.Printf("Failed to dump response %s %s: %v", in.Method, in.URL, err)
+			logs.Debug.Printf("Failed to dump response %s %s: %v", in.Method, redact.URL(in.URL), err)
 		}
 	}
 	return
@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/logs"
+)
+
+func captureDebugLog(t *testing.T) *strings.Builder {
+	t.Helper()
+	var buf strings.Builder
+	logs.Debug.SetOutput(&buf)
+	t.Cleanup(func() { logs.Debug.SetOutput(io.Discard) })
+	return &buf
+}
+
+func TestLogTransportRedactsDumpedRequestURL(t *testing.T) {
+	logged := captureDebugLog(t)
+
+	body := "layer-bytes"
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return newBodyResponse(http.StatusOK, "ok"), nil
+	})
+	rt := NewLogger(primary)
+
+	req, err := http.NewRequest(http.MethodPut, "https://example-bucket.s3.amazonaws.com/blob?X-Amz-Signature=secret&keep=1", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("http.NewRequest: %v", err)
+	}
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	if strings.Contains(logged.String(), "X-Amz-Signature") {
+		t.Errorf("dumped request leaked X-Amz-Signature:\n%s", logged.String())
+	}
+	if !strings.Contains(logged.String(), "keep=1") {
+		t.Errorf("dumped request dropped a non-sensitive query param:\n%s", logged.String())
+	}
+}
+
+func TestLogTransportPreservesBodyAfterDump(t *testing.T) {
+	captureDebugLog(t)
+
+	const body = "layer-bytes"
+	var seen string
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("reading inner body: %v", err)
+		}
+		seen = string(b)
+		return newBodyResponse(http.StatusOK, "ok"), nil
+	})
+	rt := NewLogger(primary)
+
+	req, err := http.NewRequest(http.MethodPut, "https://example.com/blob", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("http.NewRequest: %v", err)
+	}
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if seen != body {
+		t.Errorf("inner transport saw body %q, want %q (dump consumed it)", seen, body)
+	}
+}
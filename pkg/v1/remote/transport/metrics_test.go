@@ -0,0 +1,57 @@
+// Copyright 2024 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestMetricsDoesNotLabelByRepository guards against unbounded label
+// cardinality: repository names are user-controlled and effectively
+// unlimited, so they must never appear as a label value on any
+// collector registered by NewMetrics.
+func TestMetricsDoesNotLabelByRepository(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return newBodyResponse(http.StatusOK, "ok"), nil
+	})
+	rt := NewMetrics(primary, reg)
+
+	req := mustRequest(t, http.MethodGet, "https://registry.example.com/v2/some/very/specific/repository/manifests/latest")
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "repository" {
+					t.Errorf("metric %s has a %q label (value %q); repository must not be a label to avoid cardinality blowup", mf.GetName(), l.GetName(), l.GetValue())
+				}
+				if l.GetValue() == "some/very/specific/repository" {
+					t.Errorf("metric %s leaked the repository name %q as a label value", mf.GetName(), l.GetValue())
+				}
+			}
+		}
+	}
+}
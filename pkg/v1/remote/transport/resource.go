@@ -0,0 +1,61 @@
+// Copyright 2024 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import "strings"
+
+// resource describes the registry object a v2 API request path refers
+// to, e.g. /v2/<repository>/manifests/<reference>.
+type resource struct {
+	repository string
+	kind       string // "manifests", "blobs", "blobs/uploads", "tags", "referrers"
+	reference  string // path after kind: digest or tag, upload ID for blobs/uploads, "list" for tags
+}
+
+// parseResource extracts repository/kind/reference information from a
+// registry API request path. It returns the zero value if path doesn't
+// look like a v2 API path this package knows how to parse.
+func parseResource(path string) resource {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v2" {
+		return resource{}
+	}
+	parts = parts[1:]
+
+	for i, kind := range parts {
+		switch kind {
+		case "manifests", "tags", "referrers":
+			return resource{
+				repository: strings.Join(parts[:i], "/"),
+				kind:       kind,
+				reference:  strings.Join(parts[i+1:], "/"),
+			}
+		case "blobs":
+			if i+1 < len(parts) && parts[i+1] == "uploads" {
+				return resource{
+					repository: strings.Join(parts[:i], "/"),
+					kind:       "blobs/uploads",
+					reference:  strings.Join(parts[i+2:], "/"),
+				}
+			}
+			return resource{
+				repository: strings.Join(parts[:i], "/"),
+				kind:       "blobs",
+				reference:  strings.Join(parts[i+1:], "/"),
+			}
+		}
+	}
+	return resource{}
+}
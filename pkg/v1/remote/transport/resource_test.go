@@ -0,0 +1,60 @@
+// Copyright 2024 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import "testing"
+
+func TestParseResource(t *testing.T) {
+	tests := []struct {
+		path string
+		want resource
+	}{{
+		path: "/v2/foo/bar/manifests/latest",
+		want: resource{repository: "foo/bar", kind: "manifests", reference: "latest"},
+	}, {
+		path: "/v2/foo/bar/manifests/sha256:deadbeef",
+		want: resource{repository: "foo/bar", kind: "manifests", reference: "sha256:deadbeef"},
+	}, {
+		path: "/v2/foo/blobs/sha256:deadbeef",
+		want: resource{repository: "foo", kind: "blobs", reference: "sha256:deadbeef"},
+	}, {
+		path: "/v2/foo/blobs/uploads/",
+		want: resource{repository: "foo", kind: "blobs/uploads", reference: ""},
+	}, {
+		path: "/v2/foo/blobs/uploads/some-upload-id",
+		want: resource{repository: "foo", kind: "blobs/uploads", reference: "some-upload-id"},
+	}, {
+		path: "/v2/foo/tags/list",
+		want: resource{repository: "foo", kind: "tags", reference: "list"},
+	}, {
+		path: "/v2/foo/referrers/sha256:deadbeef",
+		want: resource{repository: "foo", kind: "referrers", reference: "sha256:deadbeef"},
+	}, {
+		path: "/v2/",
+		want: resource{},
+	}, {
+		path: "/not-v2/foo/manifests/latest",
+		want: resource{},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := parseResource(tt.path)
+			if got != tt.want {
+				t.Errorf("parseResource(%q) = %+v, want %+v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2024 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-containerregistry/internal/redact"
+)
+
+// StructuredEvent is a single JSON-encodable record of one RoundTrip,
+// emitted by a transport created with NewStructuredLogger.
+type StructuredEvent struct {
+	Timestamp       time.Time         `json:"timestamp"`
+	Method          string            `json:"method"`
+	URL             string            `json:"url"`
+	Status          int               `json:"status,omitempty"`
+	DurationMS      int64             `json:"duration_ms"`
+	RequestBytes    int64             `json:"request_bytes"`
+	ResponseBytes   int64             `json:"response_bytes"`
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	RequestBody     string            `json:"request_body,omitempty"`
+	ResponseBody    string            `json:"response_body,omitempty"`
+	Redacted        bool              `json:"redacted,omitempty"`
+	RedactedReason  string            `json:"redacted_reason,omitempty"`
+	Attempt         int               `json:"attempt,omitempty"`
+	RetriedFrom     int               `json:"retried_from,omitempty"`
+	Error           string            `json:"error,omitempty"`
+}
+
+type structuredTransport struct {
+	inner http.RoundTripper
+	sink  io.Writer
+	mu    sync.Mutex
+
+	maxBodySize  int
+	headerAllow  map[string]bool
+	headerDeny   map[string]bool
+	base64Bodies bool
+
+	attempts int64 // total round trips seen by this transport, for Attempt
+}
+
+// StructuredOption customizes a transport created by NewStructuredLogger.
+type StructuredOption func(*structuredTransport)
+
+// WithMaxBodySize caps how many bytes of a request/response body are
+// captured in each event; only that many bytes are ever read into
+// memory; bodies longer than n have their captured portion truncated
+// and noted with a marker, while the rest streams through to the real
+// caller untouched. The default is 4096. A non-positive n disables body
+// capture entirely; only sizes are recorded.
+func WithMaxBodySize(n int) StructuredOption {
+	return func(t *structuredTransport) { t.maxBodySize = n }
+}
+
+// WithHeaderAllowlist restricts captured headers to names (matched
+// case-insensitively). It's mutually exclusive with
+// WithHeaderDenylist; whichever option is applied last wins.
+func WithHeaderAllowlist(names ...string) StructuredOption {
+	return func(t *structuredTransport) {
+		t.headerAllow = toHeaderSet(names)
+		t.headerDeny = nil
+	}
+}
+
+// WithHeaderDenylist omits the named headers (matched case-insensitively)
+// from captured events. Authorization is always omitted regardless of
+// this option.
+func WithHeaderDenylist(names ...string) StructuredOption {
+	return func(t *structuredTransport) {
+		t.headerDeny = toHeaderSet(names)
+		t.headerAllow = nil
+	}
+}
+
+// WithBase64Bodies captures bodies whose Content-Type isn't text as
+// base64 instead of omitting them.
+func WithBase64Bodies(enabled bool) StructuredOption {
+	return func(t *structuredTransport) { t.base64Bodies = enabled }
+}
+
+func toHeaderSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[strings.ToLower(n)] = true
+	}
+	return set
+}
+
+type retryContextKey struct{}
+
+// WithRetryContext returns a context that a wrapping retry transport can
+// use to tell a structured logger which earlier attempt a request is
+// retrying, so that the emitted event's RetriedFrom is populated.
+func WithRetryContext(ctx context.Context, retriedFrom int) context.Context {
+	return context.WithValue(ctx, retryContextKey{}, retriedFrom)
+}
+
+// NewStructuredLogger returns a transport that writes one JSON-encoded
+// StructuredEvent per RoundTrip to sink, suitable for production audit
+// trails or for feeding registry traffic into log-analysis pipelines.
+// It's independent of NewLogger, whose plain-text behavior to
+// github.com/google/go-containerregistry/pkg/logs.Debug is unaffected;
+// use this instead when structured output is needed.
+func NewStructuredLogger(inner http.RoundTripper, sink io.Writer, opts ...StructuredOption) http.RoundTripper {
+	t := &structuredTransport{
+		inner:       inner,
+		sink:        sink,
+		maxBodySize: 4096,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+func (t *structuredTransport) RoundTrip(in *http.Request) (*http.Response, error) {
+	attempt := atomic.AddInt64(&t.attempts, 1)
+	omitBody, reason := redact.FromContext(in.Context())
+
+	event := StructuredEvent{
+		Timestamp:      time.Now(),
+		Method:         in.Method,
+		URL:            redact.URL(in.URL).String(),
+		Redacted:       omitBody,
+		RedactedReason: reason,
+		Attempt:        int(attempt),
+	}
+	if rf, ok := in.Context().Value(retryContextKey{}).(int); ok {
+		event.RetriedFrom = rf
+	}
+
+	event.RequestHeaders = t.captureHeaders(in.Header)
+	var reqFinal func() int64
+	if !omitBody {
+		event.RequestBody, event.RequestBytes, reqFinal = t.captureBody(in.Header.Get("Content-Type"), in.ContentLength, &in.Body)
+	}
+
+	start := time.Now()
+	out, err := t.inner.RoundTrip(in)
+	event.DurationMS = time.Since(start).Milliseconds()
+
+	// The inner transport always fully sends (and closes) the request
+	// body before returning, so reqFinal's done channel is already
+	// closed here; this just swaps in the real total in place of the
+	// capped-capture guess for chunked bodies bigger than the cap.
+	if reqFinal != nil {
+		event.RequestBytes = reqFinal()
+	}
+
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	var resFinal func() int64
+	if out != nil {
+		event.Status = out.StatusCode
+		event.ResponseHeaders = t.captureHeaders(out.Header)
+		if !omitBody {
+			event.ResponseBody, event.ResponseBytes, resFinal = t.captureBody(out.Header.Get("Content-Type"), out.ContentLength, &out.Body)
+		}
+	}
+
+	if resFinal == nil {
+		t.write(event)
+		return out, err
+	}
+
+	// The response body is handed back to the real caller, so the real
+	// total (unlike the request's) isn't known yet. Defer writing the
+	// event until they finish reading it rather than logging the
+	// capped-capture guess as if it were the whole body.
+	go func() {
+		event.ResponseBytes = resFinal()
+		t.write(event)
+	}()
+	return out, err
+}
+
+func (t *structuredTransport) captureHeaders(h http.Header) map[string]string {
+	if len(h) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		lk := strings.ToLower(k)
+		if lk == "authorization" {
+			continue
+		}
+		if t.headerAllow != nil && !t.headerAllow[lk] {
+			continue
+		}
+		if t.headerDeny != nil && t.headerDeny[lk] {
+			continue
+		}
+		out[k] = strings.Join(v, ", ")
+	}
+	return out
+}
+
+// captureBody reads at most t.maxBodySize+1 bytes of *body -- never the
+// whole thing -- so that multi-gigabyte blob push/pull bodies are never
+// fully materialized in memory. It replaces *body with a reader that
+// reproduces the full original content (the captured prefix followed by
+// whatever of the stream remains unread) so the real caller still sees
+// every byte, and returns a string representation of the captured
+// prefix (truncated with a marker if the body was longer, and
+// base64-encoded for non-text content if WithBase64Bodies is set)
+// along with the body's length -- taken from declaredLength (usually
+// the Content-Length header) when known, or the number of bytes read
+// otherwise.
+//
+// If declaredLength is unknown (e.g. chunked encoding) and the body is
+// longer than the capture cap, the returned length is only a lower
+// bound (the size of the captured prefix); the real total isn't known
+// until whoever holds *body afterward reads it to EOF. In that case
+// captureBody also returns a non-nil func that blocks until that
+// happens and returns the real total, so callers that can wait for it
+// (or that already know the reader has been drained) get an accurate
+// count instead of silently under-reporting it.
+func (t *structuredTransport) captureBody(contentType string, declaredLength int64, body *io.ReadCloser) (string, int64, func() int64) {
+	if body == nil || *body == nil {
+		return "", 0, nil
+	}
+	orig := *body
+
+	isText := isTextContentType(contentType)
+	if !isText && !t.base64Bodies {
+		// Nothing to capture: don't read a single byte ourselves, just
+		// pass the declared length (if any) through untouched.
+		return "", declaredLength, nil
+	}
+	if t.maxBodySize <= 0 {
+		return "", declaredLength, nil
+	}
+
+	prefix := make([]byte, t.maxBodySize+1)
+	n, rerr := io.ReadFull(orig, prefix)
+	if rerr != nil && rerr != io.ErrUnexpectedEOF && rerr != io.EOF {
+		// Put back what little we might have read and give up on
+		// capture; the caller still needs the rest of the stream.
+		*body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(prefix[:n]), orig), orig}
+		return "", declaredLength, nil
+	}
+	prefix = prefix[:n]
+
+	display := prefix
+	marker := ""
+	truncated := n > t.maxBodySize
+	if truncated {
+		display = prefix[:t.maxBodySize]
+		if declaredLength >= 0 {
+			marker = fmt.Sprintf("...<truncated %d bytes>", declaredLength-int64(t.maxBodySize))
+		} else {
+			marker = "...<truncated>"
+		}
+	}
+	text := string(display) + marker
+	if !isText {
+		text = base64.StdEncoding.EncodeToString(display) + marker
+	}
+
+	if declaredLength >= 0 || !truncated {
+		// Either the length was already known, or we captured the
+		// whole body (it was no longer than the cap) so n is the real
+		// total even though Content-Length wasn't declared.
+		*body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(prefix), orig), orig}
+		length := declaredLength
+		if length < 0 {
+			length = int64(n)
+		}
+		return text, length, nil
+	}
+
+	// Chunked (or otherwise length-unknown) and longer than the cap: n
+	// only counts the captured prefix. Count the rest of the stream as
+	// it passes through to whoever reads *body next, so the real total
+	// is available once they finish.
+	cb := &countingBody{
+		Reader: io.MultiReader(bytes.NewReader(prefix), orig),
+		closer: orig,
+		done:   make(chan struct{}),
+	}
+	*body = cb
+	return text, int64(n), func() int64 {
+		<-cb.done
+		return cb.total
+	}
+}
+
+// countingBody wraps a body reconstructed by captureBody (a buffered
+// prefix followed by the unread remainder of the original stream) to
+// track the real total byte count as it's consumed, for the one case
+// captureBody can't already report an exact length: chunked bodies
+// longer than the capture cap. close(done) signals that total is
+// final.
+type countingBody struct {
+	io.Reader
+	closer io.Closer
+	total  int64
+	done   chan struct{}
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.total += int64(n)
+	return n, err
+}
+
+func (c *countingBody) Close() error {
+	err := c.closer.Close()
+	close(c.done)
+	return err
+}
+
+func isTextContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
+
+func (t *structuredTransport) write(event StructuredEvent) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sink.Write(b)
+}
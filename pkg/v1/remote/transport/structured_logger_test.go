@@ -0,0 +1,249 @@
+// Copyright 2024 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingReader records how many bytes have been read from it so far,
+// so a test can check how much a transport read eagerly (e.g. during
+// RoundTrip, before handing the body back) versus lazily as the real
+// caller streams the rest afterward.
+type countingReader struct {
+	r    io.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestStructuredLoggerBoundsBodyCapture(t *testing.T) {
+	const maxBodySize = 16
+	full := strings.Repeat("x", 10_000_000) // ~10MB, would OOM buffers at scale if read in full
+
+	body := &countingReader{r: strings.NewReader(full)}
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Content-Type": []string{"text/plain"}},
+			Body:          io.NopCloser(body),
+			ContentLength: int64(len(full)),
+		}, nil
+	})
+
+	var sink bytes.Buffer
+	rt := NewStructuredLogger(primary, &sink, WithMaxBodySize(maxBodySize))
+
+	req := mustRequest(t, http.MethodGet, "https://registry.example.com/v2/foo/blobs/sha256:deadbeef")
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	// RoundTrip must only have read the bounded capture prefix so far,
+	// never the whole multi-megabyte body.
+	if body.read > maxBodySize+1 {
+		t.Fatalf("RoundTrip read %d bytes before returning, exceeding bound of %d: body was fully buffered", body.read, maxBodySize+1)
+	}
+
+	// The real caller must still be able to read the full original body.
+	got, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading response body: %v", err)
+	}
+	if string(got) != full {
+		t.Errorf("response body length = %d, want %d (body was corrupted or truncated for the real caller)", len(got), len(full))
+	}
+
+	var event StructuredEvent
+	if err := json.Unmarshal(sink.Bytes(), &event); err != nil {
+		t.Fatalf("decoding structured event: %v", err)
+	}
+	if event.ResponseBytes != int64(len(full)) {
+		t.Errorf("event.ResponseBytes = %d, want %d", event.ResponseBytes, len(full))
+	}
+	if !strings.Contains(event.ResponseBody, "truncated") {
+		t.Errorf("event.ResponseBody = %q, want a truncation marker", event.ResponseBody)
+	}
+	if len(event.ResponseBody) > maxBodySize+40 {
+		t.Errorf("event.ResponseBody is %d bytes, want roughly capped at maxBodySize", len(event.ResponseBody))
+	}
+}
+
+func TestStructuredLoggerSmallBodyNotTruncated(t *testing.T) {
+	const body = "short body"
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Content-Type": []string{"text/plain"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+		}, nil
+	})
+
+	var sink bytes.Buffer
+	rt := NewStructuredLogger(primary, &sink, WithMaxBodySize(4096))
+
+	req := mustRequest(t, http.MethodGet, "https://registry.example.com/v2/foo/manifests/latest")
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	io.ReadAll(res.Body)
+
+	var event StructuredEvent
+	if err := json.Unmarshal(sink.Bytes(), &event); err != nil {
+		t.Fatalf("decoding structured event: %v", err)
+	}
+	if event.ResponseBody != body {
+		t.Errorf("event.ResponseBody = %q, want %q", event.ResponseBody, body)
+	}
+}
+
+func TestStructuredLoggerSkipsNonTextBinaryBody(t *testing.T) {
+	full := strings.Repeat("\x00\x01", 5_000_000)
+	body := &countingReader{r: strings.NewReader(full)}
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Content-Type": []string{"application/octet-stream"}},
+			Body:          io.NopCloser(body),
+			ContentLength: int64(len(full)),
+		}, nil
+	})
+
+	var sink bytes.Buffer
+	rt := NewStructuredLogger(primary, &sink, WithMaxBodySize(16))
+
+	req := mustRequest(t, http.MethodGet, "https://registry.example.com/v2/foo/blobs/sha256:deadbeef")
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	// Non-text content isn't captured at all, so RoundTrip shouldn't
+	// have read anything eagerly.
+	if body.read != 0 {
+		t.Fatalf("RoundTrip read %d bytes of a non-captured binary body, want 0", body.read)
+	}
+
+	got, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading response body: %v", err)
+	}
+	if string(got) != full {
+		t.Errorf("response body was altered for non-text content: got %d bytes, want %d", len(got), len(full))
+	}
+
+	var event StructuredEvent
+	if err := json.Unmarshal(sink.Bytes(), &event); err != nil {
+		t.Fatalf("decoding structured event: %v", err)
+	}
+	if event.ResponseBody != "" {
+		t.Errorf("event.ResponseBody = %q, want empty (binary body, base64 capture disabled)", event.ResponseBody)
+	}
+	if event.ResponseBytes != int64(len(full)) {
+		t.Errorf("event.ResponseBytes = %d, want %d", event.ResponseBytes, len(full))
+	}
+}
+
+// TestStructuredLoggerReportsAccurateByteCountForChunkedBody guards
+// against silently under-reporting ResponseBytes for a chunked (declared
+// length unknown) body bigger than the capture cap: the event should
+// eventually reflect the real total, not just the size of the capped
+// capture buffer.
+func TestStructuredLoggerReportsAccurateByteCountForChunkedBody(t *testing.T) {
+	const maxBodySize = 16
+	full := strings.Repeat("y", 1_000_000)
+
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Content-Type": []string{"text/plain"}},
+			Body:          io.NopCloser(strings.NewReader(full)),
+			ContentLength: -1, // chunked: length unknown ahead of time
+		}, nil
+	})
+
+	sink := &syncBuffer{}
+	rt := NewStructuredLogger(primary, sink, WithMaxBodySize(maxBodySize))
+
+	req := mustRequest(t, http.MethodGet, "https://registry.example.com/v2/foo/blobs/sha256:deadbeef")
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	got, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading response body: %v", err)
+	}
+	if len(got) != len(full) {
+		t.Fatalf("read %d bytes, want %d", len(got), len(full))
+	}
+	res.Body.Close()
+
+	// The event is written asynchronously in this case, since the real
+	// total isn't known until the body above is fully read and closed.
+	deadline := time.Now().Add(time.Second)
+	for sink.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	var event StructuredEvent
+	if err := json.Unmarshal(sink.Bytes(), &event); err != nil {
+		t.Fatalf("decoding structured event: %v", err)
+	}
+	if event.ResponseBytes != int64(len(full)) {
+		t.Errorf("event.ResponseBytes = %d, want %d (the real total, not just the capped capture size)", event.ResponseBytes, len(full))
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for one writer goroutine racing a
+// reader goroutine, since structuredTransport can write its event from
+// a background goroutine after the response body is fully drained.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuffer) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Len()
+}
+
+func (s *syncBuffer) Bytes() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte(nil), s.buf.Bytes()...)
+}
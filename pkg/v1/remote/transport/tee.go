@@ -0,0 +1,401 @@
+// Copyright 2024 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/logs"
+)
+
+// defaultTeeMaxMirrorBodySize bounds how much of a request's body
+// cloneRequest will buffer to replay against shadow. Blob PUT bodies
+// (container layers) are routinely multi-gigabyte; buffering them
+// whole for every mirrored write would risk an out-of-memory transport
+// in exactly the dual-write/strict validation scenarios this package
+// exists for.
+const defaultTeeMaxMirrorBodySize = 32 << 20 // 32MiB
+
+// defaultTeeShadowTimeout bounds how long a mirrored shadow call is
+// allowed to run, once detached from the caller's own context (see
+// WithTeeShadowTimeout).
+const defaultTeeShadowTimeout = 30 * time.Second
+
+// errBodyTooLargeToMirror is returned by cloneRequest when a request
+// body is larger than the configured mirror cap; RoundTrip treats it as
+// "skip mirroring this one," not a hard error.
+var errBodyTooLargeToMirror = errors.New("tee: request body too large to mirror")
+
+// TeeMode controls how NewTee treats unsafe (mutating) requests.
+type TeeMode int
+
+const (
+	// TeeModeReadOnly skips mirroring unsafe requests to the shadow
+	// transport entirely; only GET/HEAD traffic is compared. This is
+	// the default.
+	TeeModeReadOnly TeeMode = iota
+	// TeeModeDualWrite replays an unsafe request against shadow, after
+	// it has succeeded against primary. Replay transport errors are
+	// logged via logs.Debug and never fail the caller's request; the
+	// shadow response is not compared to primary's and no TeeDiff is
+	// reported for it.
+	TeeModeDualWrite
+	// TeeModeStrict replays an unsafe request against shadow after it
+	// succeeds against primary, and fails the caller's request if
+	// shadow diverges.
+	TeeModeStrict
+)
+
+// TeeDiff describes an observed difference between the primary and
+// shadow registry's response to the same request.
+type TeeDiff struct {
+	Request       *http.Request
+	PrimaryStatus int
+	ShadowStatus  int
+	PrimaryDigest string
+	ShadowDigest  string
+	PrimaryErr    error
+	ShadowErr     error
+	// Diverged is true if the primary and shadow responses disagree on
+	// error-ness, status code, or digest.
+	Diverged bool
+}
+
+type teeTransport struct {
+	primary, shadow   http.RoundTripper
+	mode              TeeMode
+	onDiff            func(TeeDiff)
+	maxMirrorBodySize int64
+	shadowTimeout     time.Duration
+}
+
+// TeeOption customizes the behavior of NewTee.
+type TeeOption func(*teeTransport)
+
+// WithTeeMode sets how unsafe (PUT/POST/PATCH/DELETE) requests are
+// mirrored to the shadow transport. The default is TeeModeReadOnly.
+func WithTeeMode(mode TeeMode) TeeOption {
+	return func(t *teeTransport) { t.mode = mode }
+}
+
+// WithTeeDiffCallback registers a callback invoked with a TeeDiff after
+// every compared request: each mirrored GET/HEAD and each TeeModeStrict
+// replay. TeeModeDualWrite replays are not compared and don't invoke it.
+// The callback runs for every diff, diverged or not, in addition to the
+// default logs.Debug report, which is written only for divergences.
+func WithTeeDiffCallback(f func(TeeDiff)) TeeOption {
+	return func(t *teeTransport) { t.onDiff = f }
+}
+
+// WithTeeMaxMirrorBodySize caps how much of a request's body NewTee
+// will buffer in order to replay it against shadow. Requests whose body
+// is larger than n are still sent to primary as normal; they're just
+// never mirrored to shadow, and the skip is logged via logs.Debug. The
+// default is 32MiB. A non-positive n disables mirroring of any request
+// that has a body.
+func WithTeeMaxMirrorBodySize(n int64) TeeOption {
+	return func(t *teeTransport) { t.maxMirrorBodySize = n }
+}
+
+// WithTeeShadowTimeout bounds how long a mirrored call to shadow is
+// allowed to run. It does not share the caller's own context/deadline:
+// safe-method mirroring is asynchronous specifically so it never adds
+// latency to the caller, which means it often starts only after the
+// caller's own request (and its context) has already finished, so
+// reusing that context would report the shadow call as having "failed"
+// with context canceled/deadline exceeded on every such mirror. The
+// default is 30s.
+func WithTeeShadowTimeout(d time.Duration) TeeOption {
+	return func(t *teeTransport) { t.shadowTimeout = d }
+}
+
+// NewTee returns a transport that sends every request to primary and
+// mirrors it to shadow, comparing status codes, Docker-Content-Digest
+// headers, and response body digests between the two. GET/HEAD requests
+// are mirrored asynchronously so they never add latency to the caller.
+// Differences are reported via logs.Debug and any callback registered
+// with WithTeeDiffCallback.
+//
+// Unsafe methods (PUT/POST/PATCH/DELETE) are only ever replayed against
+// shadow after they've succeeded against primary, and only if opts
+// configures a mode other than the default TeeModeReadOnly; see
+// TeeModeDualWrite and TeeModeStrict. This lets operators validate a new
+// registry against an old one using production traffic before cutting
+// over, without out-of-band scripts.
+func NewTee(primary, shadow http.RoundTripper, opts ...TeeOption) http.RoundTripper {
+	t := &teeTransport{
+		primary:           primary,
+		shadow:            shadow,
+		mode:              TeeModeReadOnly,
+		maxMirrorBodySize: defaultTeeMaxMirrorBodySize,
+		shadowTimeout:     defaultTeeShadowTimeout,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+func (t *teeTransport) RoundTrip(in *http.Request) (*http.Response, error) {
+	mirror := isSafeMethod(in.Method) || t.mode != TeeModeReadOnly
+
+	var shadowReq *http.Request
+	var shadowCancel context.CancelFunc
+	if mirror {
+		// Detached from in.Context(): mirroring (especially the async
+		// safe-method path) frequently runs after the caller's own
+		// request, and often its context, has already finished.
+		shadowCtx, cancel := context.WithTimeout(context.Background(), t.shadowTimeout)
+		req, cerr := cloneRequest(in, shadowCtx, t.maxMirrorBodySize)
+		if cerr != nil {
+			cancel()
+			if errors.Is(cerr, errBodyTooLargeToMirror) {
+				logs.Debug.Printf("tee: skipping shadow mirror for %s %s: body exceeds %d bytes", in.Method, in.URL, t.maxMirrorBodySize)
+			} else {
+				logs.Debug.Printf("tee: failed to clone request for shadow mirror: %v", cerr)
+			}
+			mirror = false
+		} else {
+			shadowReq, shadowCancel = req, cancel
+		}
+	}
+
+	out, err := t.primary.RoundTrip(in)
+	if !mirror {
+		return out, err
+	}
+
+	if isSafeMethod(in.Method) {
+		// out.Body is about to be handed back to the real caller, who
+		// will Read/Close it concurrently with whatever we do in the
+		// background. We must not touch it ourselves once RoundTrip
+		// returns, so capture it into a buffer as the caller drains it
+		// and only inspect that buffer, from the background goroutine,
+		// after the caller has closed it.
+		if out != nil && out.Body != nil && out.Header.Get("Docker-Content-Digest") == "" {
+			cb := &capturingBody{rc: out.Body, done: make(chan struct{})}
+			out.Body = cb
+			go func() {
+				<-cb.done
+				t.compare(in, shadowReq, shadowCancel, out, err, cb.buf.Bytes())
+			}()
+		} else {
+			go t.compare(in, shadowReq, shadowCancel, out, err, nil)
+		}
+		return out, err
+	}
+
+	// Unsafe method: only replay once primary has succeeded.
+	if err != nil || out == nil || out.StatusCode >= 400 {
+		shadowCancel()
+		return out, err
+	}
+
+	switch t.mode {
+	case TeeModeDualWrite:
+		go t.replay(shadowReq, shadowCancel)
+	case TeeModeStrict:
+		shadowOut, shadowErr := t.shadow.RoundTrip(shadowReq)
+		shadowCancel()
+		diff := buildDiff(in, out, nil, shadowOut, shadowErr)
+		t.report(diff)
+		if diff.Diverged {
+			return out, fmt.Errorf("transport: shadow registry diverged from primary for %s %s: primary_status=%d shadow_status=%d", in.Method, in.URL, diff.PrimaryStatus, diff.ShadowStatus)
+		}
+	}
+
+	return out, err
+}
+
+// compare mirrors a safe request to shadow and reports how its response
+// compares to the one already returned from primary. It's always run in
+// its own goroutine so it never adds latency to the caller.
+//
+// primaryBody is the (possibly partial, if the real caller didn't read
+// it to EOF) body already captured off of primaryOut.Body by the
+// capturingBody installed in RoundTrip; compare must not itself touch
+// primaryOut.Body, since the real caller owns it by the time this runs.
+func (t *teeTransport) compare(primaryReq, shadowReq *http.Request, shadowCancel context.CancelFunc, primaryOut *http.Response, primaryErr error, primaryBody []byte) {
+	defer shadowCancel()
+	shadowOut, shadowErr := t.shadow.RoundTrip(shadowReq)
+
+	diff := TeeDiff{Request: primaryReq, PrimaryErr: primaryErr, ShadowErr: shadowErr}
+	if primaryOut != nil {
+		diff.PrimaryStatus = primaryOut.StatusCode
+		diff.PrimaryDigest = digestFromBytes(primaryOut.Header, primaryBody)
+	}
+	if shadowOut != nil {
+		diff.ShadowStatus = shadowOut.StatusCode
+		diff.ShadowDigest = responseDigest(shadowOut)
+	}
+	diff.Diverged = (primaryErr == nil) != (shadowErr == nil) ||
+		diff.PrimaryStatus != diff.ShadowStatus ||
+		diff.PrimaryDigest != diff.ShadowDigest
+
+	t.report(diff)
+}
+
+// replay sends an already-succeeded unsafe request to shadow, fail-open:
+// errors are logged but never surfaced to the caller.
+func (t *teeTransport) replay(shadowReq *http.Request, shadowCancel context.CancelFunc) {
+	defer shadowCancel()
+	out, err := t.shadow.RoundTrip(shadowReq)
+	if err != nil {
+		logs.Debug.Printf("tee: dual-write replay to shadow failed for %s %s: %v", shadowReq.Method, shadowReq.URL, err)
+		return
+	}
+	if out.Body != nil {
+		out.Body.Close()
+	}
+}
+
+func (t *teeTransport) report(diff TeeDiff) {
+	if diff.Diverged {
+		logs.Debug.Printf("tee: shadow diverged for %s %s: primary_status=%d shadow_status=%d primary_digest=%s shadow_digest=%s primary_err=%v shadow_err=%v",
+			diff.Request.Method, diff.Request.URL, diff.PrimaryStatus, diff.ShadowStatus, diff.PrimaryDigest, diff.ShadowDigest, diff.PrimaryErr, diff.ShadowErr)
+	}
+	if t.onDiff != nil {
+		t.onDiff(diff)
+	}
+}
+
+func buildDiff(req *http.Request, primaryOut *http.Response, primaryErr error, shadowOut *http.Response, shadowErr error) TeeDiff {
+	diff := TeeDiff{Request: req, PrimaryErr: primaryErr, ShadowErr: shadowErr}
+	if primaryOut != nil {
+		diff.PrimaryStatus = primaryOut.StatusCode
+		diff.PrimaryDigest = responseDigest(primaryOut)
+	}
+	if shadowOut != nil {
+		diff.ShadowStatus = shadowOut.StatusCode
+		diff.ShadowDigest = responseDigest(shadowOut)
+	}
+	diff.Diverged = (primaryErr == nil) != (shadowErr == nil) ||
+		diff.PrimaryStatus != diff.ShadowStatus ||
+		diff.PrimaryDigest != diff.ShadowDigest
+	return diff
+}
+
+// responseDigest returns the Docker-Content-Digest header if present,
+// otherwise the sha256 digest of the response body. It consumes and
+// replaces res.Body with a rewound copy so callers can still read it
+// afterward. Only call this on a response no other goroutine holds a
+// reference to (e.g. the shadow response, which this package always
+// owns exclusively, or the primary response in TeeModeStrict before
+// RoundTrip returns it); for a primary response already handed back to
+// the real caller, use digestFromBytes with a capturingBody instead.
+func responseDigest(res *http.Response) string {
+	if d := res.Header.Get("Docker-Content-Digest"); d != "" {
+		return d
+	}
+	if res.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// digestFromBytes returns the Docker-Content-Digest header if present,
+// otherwise the sha256 digest of body.
+func digestFromBytes(header http.Header, body []byte) string {
+	if d := header.Get("Docker-Content-Digest"); d != "" {
+		return d
+	}
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// capturingBody wraps a response body, copying every byte the real
+// caller reads into buf and closing done once the caller calls Close.
+// A background goroutine can then safely read buf.Bytes() after <-done,
+// without ever racing the caller's own Read/Close calls on the
+// original body.
+type capturingBody struct {
+	rc   io.ReadCloser
+	buf  bytes.Buffer
+	done chan struct{}
+}
+
+func (b *capturingBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	if n > 0 {
+		b.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+func (b *capturingBody) Close() error {
+	err := b.rc.Close()
+	close(b.done)
+	return err
+}
+
+// cloneRequest returns a shallow clone of in suitable for replaying
+// against a second transport, under ctx rather than in.Context(), and
+// rewinding in.Body (if any) so the original request can still be sent
+// unmodified to primary.
+//
+// It reads at most maxBodySize+1 bytes of in.Body -- never the whole
+// thing -- so that a multi-gigabyte blob PUT can't be buffered whole
+// just to mirror it. If the body turns out to be longer than
+// maxBodySize, cloneRequest returns errBodyTooLargeToMirror and skips
+// mirroring this request entirely; in.Body is still restored so the
+// real request is unaffected.
+func cloneRequest(in *http.Request, ctx context.Context, maxBodySize int64) (*http.Request, error) {
+	clone := in.Clone(ctx)
+	if in.Body == nil {
+		return clone, nil
+	}
+	if maxBodySize <= 0 {
+		return nil, errBodyTooLargeToMirror
+	}
+
+	prefix := make([]byte, maxBodySize+1)
+	n, rerr := io.ReadFull(in.Body, prefix)
+	prefix = prefix[:n]
+
+	rest := in.Body
+	in.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(prefix), rest), rest}
+
+	if rerr != nil && rerr != io.ErrUnexpectedEOF && rerr != io.EOF {
+		return nil, rerr
+	}
+	if int64(n) > maxBodySize {
+		return nil, errBodyTooLargeToMirror
+	}
+
+	clone.Body = io.NopCloser(bytes.NewReader(prefix))
+	return clone, nil
+}
@@ -0,0 +1,237 @@
+// Copyright 2024 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeRoundTripper func(*http.Request) (*http.Response, error)
+
+func (f fakeRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func newBodyResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func mustRequest(t *testing.T, method, url string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest: %v", err)
+	}
+	return req
+}
+
+// TestTeePrimaryBodyIntegrity guards against a data race where the
+// background shadow comparison reads/closes the same body the real
+// caller is streaming: the caller must always see the exact, uncorrupted
+// primary body regardless of how the (slower) shadow transport behaves.
+func TestTeePrimaryBodyIntegrity(t *testing.T) {
+	want := strings.Repeat("manifest-bytes", 1000)
+
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return newBodyResponse(http.StatusOK, want), nil
+	})
+	shadow := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		time.Sleep(5 * time.Millisecond) // widen the window for a race to manifest
+		return newBodyResponse(http.StatusOK, want), nil
+	})
+
+	rt := NewTee(primary, shadow)
+
+	req := mustRequest(t, http.MethodGet, "https://registry.example.com/v2/foo/manifests/latest")
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	got, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading primary body: %v", err)
+	}
+	res.Body.Close()
+
+	if string(got) != want {
+		t.Errorf("primary body = %d bytes, want %d bytes (content mismatch: corrupted by shadow mirror)", len(got), len(want))
+	}
+}
+
+func TestTeeDiffCallbackReportsDivergence(t *testing.T) {
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return newBodyResponse(http.StatusOK, "primary-bytes"), nil
+	})
+	shadow := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return newBodyResponse(http.StatusOK, "different-bytes"), nil
+	})
+
+	diffs := make(chan TeeDiff, 1)
+	rt := NewTee(primary, shadow, WithTeeDiffCallback(func(d TeeDiff) { diffs <- d }))
+
+	req := mustRequest(t, http.MethodGet, "https://registry.example.com/v2/foo/manifests/latest")
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	io.ReadAll(res.Body)
+	res.Body.Close()
+
+	select {
+	case d := <-diffs:
+		if !d.Diverged {
+			t.Errorf("TeeDiff.Diverged = false, want true (digests differ): %+v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for diff callback")
+	}
+}
+
+func TestTeeModeReadOnlySkipsUnsafeMethods(t *testing.T) {
+	var shadowCalls int32
+
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return newBodyResponse(http.StatusCreated, ""), nil
+	})
+	shadow := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&shadowCalls, 1)
+		return newBodyResponse(http.StatusCreated, ""), nil
+	})
+
+	rt := NewTee(primary, shadow) // default TeeModeReadOnly
+
+	req := mustRequest(t, http.MethodPut, "https://registry.example.com/v2/foo/manifests/latest")
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond) // let any stray goroutine run
+	if n := atomic.LoadInt32(&shadowCalls); n != 0 {
+		t.Errorf("shadow called %d times in TeeModeReadOnly, want 0", n)
+	}
+}
+
+func TestTeeModeStrictFailsOnDivergence(t *testing.T) {
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return newBodyResponse(http.StatusCreated, ""), nil
+	})
+	shadow := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return newBodyResponse(http.StatusInternalServerError, ""), nil
+	})
+
+	rt := NewTee(primary, shadow, WithTeeMode(TeeModeStrict))
+
+	req := mustRequest(t, http.MethodPut, "https://registry.example.com/v2/foo/manifests/latest")
+	if _, err := rt.RoundTrip(req); err == nil {
+		t.Fatal("RoundTrip() error = nil, want an error for diverged shadow in TeeModeStrict")
+	}
+}
+
+// TestTeeSkipsMirroringOversizedBody guards against buffering an entire
+// multi-gigabyte blob PUT just to replay it to shadow: a body larger
+// than the configured cap must not be mirrored, but the primary request
+// must still see the complete, unmodified body.
+func TestTeeSkipsMirroringOversizedBody(t *testing.T) {
+	full := strings.Repeat("layer-byte", 1000) // 10000 bytes
+	var primarySaw string
+	var shadowCalls int32
+
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("reading primary body: %v", err)
+		}
+		primarySaw = string(b)
+		return newBodyResponse(http.StatusCreated, ""), nil
+	})
+	shadow := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&shadowCalls, 1)
+		return newBodyResponse(http.StatusCreated, ""), nil
+	})
+
+	rt := NewTee(primary, shadow, WithTeeMode(TeeModeDualWrite), WithTeeMaxMirrorBodySize(100))
+
+	req, err := http.NewRequest(http.MethodPut, "https://registry.example.com/v2/foo/blobs/uploads/abc", strings.NewReader(full))
+	if err != nil {
+		t.Fatalf("http.NewRequest: %v", err)
+	}
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	if primarySaw != full {
+		t.Errorf("primary saw %d bytes, want %d (body was consumed/corrupted while capping the shadow mirror)", len(primarySaw), len(full))
+	}
+
+	time.Sleep(10 * time.Millisecond) // let any stray goroutine run
+	if n := atomic.LoadInt32(&shadowCalls); n != 0 {
+		t.Errorf("shadow called %d times for an oversized body, want 0 (should have been skipped)", n)
+	}
+}
+
+// TestTeeDetachesShadowContextFromCaller guards against the shadow
+// mirror being tied to the caller's own (often already-finished)
+// context: canceling the caller's context immediately after RoundTrip
+// returns must not prevent the async shadow mirror from completing.
+func TestTeeDetachesShadowContextFromCaller(t *testing.T) {
+	shadowDone := make(chan struct{})
+
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return newBodyResponse(http.StatusOK, "primary-bytes"), nil
+	})
+	shadow := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		defer close(shadowDone)
+		if err := r.Context().Err(); err != nil {
+			t.Errorf("shadow request context already done: %v (shadow should be detached from the caller's context)", err)
+		}
+		return newBodyResponse(http.StatusOK, "primary-bytes"), nil
+	})
+
+	rt := NewTee(primary, shadow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://registry.example.com/v2/foo/manifests/latest", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequestWithContext: %v", err)
+	}
+
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	io.ReadAll(res.Body)
+	res.Body.Close()
+
+	// Simulate the caller's context ending right after it's done with
+	// the response, before the async shadow mirror necessarily runs.
+	cancel()
+
+	select {
+	case <-shadowDone:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for shadow mirror to run")
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2024 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/google/go-containerregistry/pkg/v1/remote/transport"
+
+type tracerTransport struct {
+	inner  http.RoundTripper
+	tracer trace.Tracer
+	prop   propagation.TextMapPropagator
+}
+
+// NewTracer returns a transport that starts an OpenTelemetry span around
+// each RoundTrip, tagging it with the HTTP method/URL/status, the
+// repository and tag/digest parsed from the request path, request and
+// response byte counts, and duration. It injects W3C traceparent and
+// tracestate headers outbound so registries and intermediate proxies can
+// correlate a push or pull with the rest of a distributed trace.
+//
+// A 401 response records the WWW-Authenticate realm as a span event,
+// and any status outside the 2xx/3xx range marks the span as errored.
+//
+// If tp is nil, the global TracerProvider from otel.GetTracerProvider
+// is used. Most callers won't call this directly: pass
+// remote.WithTracerProvider to remote.Write/remote.Pull/etc. to get
+// end-to-end traces for pushes and pulls without wrapping the transport
+// by hand.
+func NewTracer(inner http.RoundTripper, tp trace.TracerProvider) http.RoundTripper {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &tracerTransport{
+		inner:  inner,
+		tracer: tp.Tracer(tracerName),
+		prop:   propagation.TraceContext{},
+	}
+}
+
+func (t *tracerTransport) RoundTrip(in *http.Request) (*http.Response, error) {
+	res := parseResource(in.URL.Path)
+
+	ctx, span := t.tracer.Start(in.Context(), spanName(res), trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	attrs := []attribute.KeyValue{
+		attribute.String("http.method", in.Method),
+		attribute.String("http.url", in.URL.Redacted()),
+	}
+	if res.repository != "" {
+		attrs = append(attrs, attribute.String("registry.repository", res.repository))
+	}
+	if ref := res.reference; ref != "" {
+		if strings.HasPrefix(ref, "sha256:") || strings.Contains(ref, ":") {
+			attrs = append(attrs, attribute.String("registry.digest", ref))
+		} else {
+			attrs = append(attrs, attribute.String("registry.tag", ref))
+		}
+	}
+	span.SetAttributes(attrs...)
+
+	in = in.Clone(ctx)
+	t.prop.Inject(ctx, propagation.HeaderCarrier(in.Header))
+
+	if in.ContentLength > 0 {
+		span.SetAttributes(attribute.Int64("http.request_content_length", in.ContentLength))
+	}
+
+	start := time.Now()
+	out, err := t.inner.RoundTrip(in)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(time.Since(start).Milliseconds())))
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return out, err
+	}
+
+	span.SetAttributes(attribute.Int("http.status_code", out.StatusCode))
+	if out.ContentLength > 0 {
+		span.SetAttributes(attribute.Int64("http.response_content_length", out.ContentLength))
+	}
+
+	if out.StatusCode == http.StatusUnauthorized {
+		span.AddEvent("www-authenticate", trace.WithAttributes(
+			attribute.String("auth.realm", challengeRealm(out.Header.Get("WWW-Authenticate"))),
+		))
+	}
+
+	if out.StatusCode < 200 || out.StatusCode >= 400 {
+		span.SetStatus(codes.Error, fmt.Sprintf("unexpected status code %d", out.StatusCode))
+	}
+
+	return out, nil
+}
+
+// spanName returns a short, low-cardinality span name for a parsed
+// resource, falling back to "registry.request" for paths that aren't a
+// recognized v2 API shape (e.g. the /v2/ ping).
+func spanName(res resource) string {
+	if res.kind == "" {
+		return "registry.request"
+	}
+	return "registry." + res.kind
+}
+
+// challengeRealm extracts the realm parameter from a WWW-Authenticate
+// challenge header, best-effort.
+func challengeRealm(header string) string {
+	const key = `realm="`
+	i := strings.Index(header, key)
+	if i < 0 {
+		return ""
+	}
+	rest := header[i+len(key):]
+	if j := strings.Index(rest, `"`); j >= 0 {
+		return rest[:j]
+	}
+	return ""
+}
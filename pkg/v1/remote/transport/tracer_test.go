@@ -0,0 +1,184 @@
+// Copyright 2024 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// fakeRoundTripper, newBodyResponse, and mustRequest are shared test
+// helpers defined in tee_test.go.
+
+func attrValue(attrs []attribute.KeyValue, key attribute.Key) (attribute.Value, bool) {
+	for _, a := range attrs {
+		if a.Key == key {
+			return a.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracerRecordsRequestAttributesAndStatus(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return newBodyResponse(http.StatusOK, "manifest-bytes"), nil
+	})
+	rt := NewTracer(primary, tp)
+
+	req := mustRequest(t, http.MethodGet, "https://registry.example.com/v2/foo/bar/manifests/latest")
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	io.ReadAll(res.Body)
+	res.Body.Close()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+
+	if span.Name != "registry.manifests" {
+		t.Errorf("span name = %q, want %q", span.Name, "registry.manifests")
+	}
+	if v, ok := attrValue(span.Attributes, "registry.repository"); !ok || v.AsString() != "foo/bar" {
+		t.Errorf("registry.repository attribute = %v, ok=%v, want %q", v, ok, "foo/bar")
+	}
+	if v, ok := attrValue(span.Attributes, "registry.tag"); !ok || v.AsString() != "latest" {
+		t.Errorf("registry.tag attribute = %v, ok=%v, want %q", v, ok, "latest")
+	}
+	if v, ok := attrValue(span.Attributes, "http.status_code"); !ok || v.AsInt64() != http.StatusOK {
+		t.Errorf("http.status_code attribute = %v, ok=%v, want %d", v, ok, http.StatusOK)
+	}
+	if span.Status.Code == codes.Error {
+		t.Errorf("span status = %v, want non-error for a 200 response", span.Status)
+	}
+}
+
+func TestTracerRecordsDigestForDigestReference(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return newBodyResponse(http.StatusOK, ""), nil
+	})
+	rt := NewTracer(primary, tp)
+
+	req := mustRequest(t, http.MethodGet, "https://registry.example.com/v2/foo/manifests/sha256:deadbeef")
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if v, ok := attrValue(spans[0].Attributes, "registry.digest"); !ok || v.AsString() != "sha256:deadbeef" {
+		t.Errorf("registry.digest attribute = %v, ok=%v, want %q", v, ok, "sha256:deadbeef")
+	}
+	if _, ok := attrValue(spans[0].Attributes, "registry.tag"); ok {
+		t.Error("registry.tag attribute set for a digest reference, want only registry.digest")
+	}
+}
+
+func TestTracerMarksErrorStatusForServerError(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		return newBodyResponse(http.StatusInternalServerError, ""), nil
+	})
+	rt := NewTracer(primary, tp)
+
+	req := mustRequest(t, http.MethodGet, "https://registry.example.com/v2/foo/manifests/latest")
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if spans[0].Status.Code != codes.Error {
+		t.Errorf("span status = %v, want Error for a 500 response", spans[0].Status)
+	}
+}
+
+func TestTracerRecordsWWWAuthenticateEventOn401(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		res := newBodyResponse(http.StatusUnauthorized, "")
+		res.Header.Set("WWW-Authenticate", `Bearer realm="https://auth.example.com/token",service="registry.example.com"`)
+		return res, nil
+	})
+	rt := NewTracer(primary, tp)
+
+	req := mustRequest(t, http.MethodGet, "https://registry.example.com/v2/foo/manifests/latest")
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	events := spans[0].Events
+	var found bool
+	for _, e := range events {
+		if e.Name != "www-authenticate" {
+			continue
+		}
+		found = true
+		if v, ok := attrValue(e.Attributes, "auth.realm"); !ok || v.AsString() != "https://auth.example.com/token" {
+			t.Errorf("auth.realm attribute = %v, ok=%v, want %q", v, ok, "https://auth.example.com/token")
+		}
+	}
+	if !found {
+		t.Error("no www-authenticate event recorded for a 401 response")
+	}
+}
+
+func TestTracerInjectsTraceparentHeader(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	var sawTraceparent string
+	primary := fakeRoundTripper(func(r *http.Request) (*http.Response, error) {
+		sawTraceparent = r.Header.Get("traceparent")
+		return newBodyResponse(http.StatusOK, ""), nil
+	})
+	rt := NewTracer(primary, tp)
+
+	req := mustRequest(t, http.MethodGet, "https://registry.example.com/v2/foo/manifests/latest")
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	if sawTraceparent == "" {
+		t.Error("outbound request is missing a traceparent header")
+	}
+}